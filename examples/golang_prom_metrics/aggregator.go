@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	aggregatorTargets  = flag.String("aggregator.targets", "", "Comma-separated list of upstream /metrics URLs to scrape and re-expose (federation mode). Disabled when empty.")
+	aggregatorInterval = flag.Duration("aggregator.interval", 15*time.Second, "How often to scrape aggregator.targets.")
+
+	scrapeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "scrape_duration_seconds",
+		Help:    "Duration of scrapes against federated targets.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"target"})
+	scrapeSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scrape_success",
+		Help: "Whether the last scrape of a federated target succeeded (1) or not (0).",
+	}, []string{"target"})
+)
+
+func init() {
+	reg.MustRegister(scrapeDuration)
+	reg.MustRegister(scrapeSuccess)
+}
+
+// aggregator periodically scrapes a fixed set of upstream /metrics
+// endpoints and re-exposes their last successfully scraped series, merged
+// and labelled by target, on its own /federate endpoint.
+type aggregator struct {
+	client  *retryablehttp.Client
+	targets []string
+
+	mu       sync.RWMutex
+	families map[string]map[string]*dto.MetricFamily // target -> metric name -> family
+}
+
+func newAggregator(targets []string) *aggregator {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.HTTPClient.Timeout = envDuration("TIMEOUT", 5*time.Second)
+	client.RetryMax = envInt("RETRIES", 3)
+
+	return &aggregator{
+		client:   client,
+		targets:  targets,
+		families: make(map[string]map[string]*dto.MetricFamily, len(targets)),
+	}
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+// run scrapes every target once per interval until ctx is cancelled.
+func (a *aggregator) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, target := range a.targets {
+			a.scrape(target)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *aggregator) scrape(target string) {
+	start := time.Now()
+	resp, err := a.client.Get(target)
+	scrapeDuration.WithLabelValues(target).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		scrapeSuccess.WithLabelValues(target).Set(0)
+		logger.Printf("scrape %s: %v", target, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		scrapeSuccess.WithLabelValues(target).Set(0)
+		logger.Printf("scrape %s: status %d", target, resp.StatusCode)
+		return
+	}
+
+	families, err := new(expfmt.TextParser).TextToMetricFamilies(resp.Body)
+	if err != nil {
+		scrapeSuccess.WithLabelValues(target).Set(0)
+		logger.Printf("scrape %s: parse: %v", target, err)
+		return
+	}
+
+	a.mu.Lock()
+	a.families[target] = families
+	a.mu.Unlock()
+	scrapeSuccess.WithLabelValues(target).Set(1)
+}
+
+// targetLabel is added to every series re-exposed on /federate, naming the
+// upstream target it was scraped from, the way real Prometheus federation
+// does.
+const targetLabel = "target"
+
+// ServeHTTP merges the most recently scraped metric families of all
+// targets, tagging each series with a target label, and re-encodes them
+// once in Prometheus text exposition format. Merging at the metric-family
+// level (rather than concatenating raw scrape bodies) keeps the output
+// valid even when multiple targets export identically named series, such
+// as the go_*/process_* series every instance of this binary registers.
+func (a *aggregator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	merged := make(map[string]*dto.MetricFamily)
+	for _, target := range a.targets {
+		for name, fam := range a.families[target] {
+			out, ok := merged[name]
+			if !ok {
+				out = &dto.MetricFamily{Name: fam.Name, Help: fam.Help, Type: fam.Type}
+				merged[name] = out
+			}
+			for _, m := range fam.Metric {
+				out.Metric = append(out.Metric, withTargetLabel(m, target))
+			}
+		}
+	}
+	a.mu.RUnlock()
+
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	format := expfmt.NewFormat(expfmt.TypeTextPlain)
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format)
+	for _, name := range names {
+		if err := enc.Encode(merged[name]); err != nil {
+			logger.Printf("federate: encode %s: %v", name, err)
+			return
+		}
+	}
+}
+
+// withTargetLabel returns a copy of m with a target label identifying
+// which upstream it was scraped from.
+func withTargetLabel(m *dto.Metric, target string) *dto.Metric {
+	cp := proto.Clone(m).(*dto.Metric)
+	cp.Label = append(cp.Label, &dto.LabelPair{
+		Name:  proto.String(targetLabel),
+		Value: proto.String(target),
+	})
+	return cp
+}
+
+func parseTargets(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	var targets []string
+	for _, t := range strings.Split(raw, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
@@ -1,42 +1,200 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"log"
+	"math"
+	mathrand "math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Logger is the minimal interface this package needs to report what it's
+// doing, so consumers can plug in their own structured logger instead of
+// the standard library's.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
 var (
-	requestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	listenAddress     = flag.String("listen-address", ":8080", "The address to listen on for HTTP requests.")
+	metricsPath       = flag.String("metrics-path", "/metrics", "The path under which to expose metrics.")
+	uniformDomain     = flag.Float64("uniform.domain", 0.0002, "The domain for the uniform distribution.")
+	normalDomain      = flag.Float64("normal.domain", 0.0002, "The domain for the normal distribution.")
+	normalMean        = flag.Float64("normal.mean", 0.00001, "The mean for the normal distribution.")
+	oscillationPeriod = flag.Duration("oscillation-period", 10*time.Minute, "The duration of the rate oscillation period.")
+
+	logger Logger = log.New(os.Stderr, "", log.LstdFlags)
+
+	reg = prometheus.NewRegistry()
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "requests_total",
 		Help: "Total number of requests",
-	})
-	requestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	}, []string{"method", "path", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "request_duration_seconds",
 		Help:    "Duration of requests in seconds",
-		Buckets: prometheus.DefBuckets,
-	})
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"method", "path"})
+
+	// rpcDurations simulates RPC latency from three fictitious upstream
+	// services so scraped data exhibits realistic, varied shapes.
+	rpcDurations = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name:       "rpc_durations_seconds",
+		Help:       "RPC latency distributions by service.",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	}, []string{"service"})
+	// rpcDurationsHistogram is built in main, once flag.Parse has run, since
+	// its bucket layout is derived from normalMean/normalDomain.
+	rpcDurationsHistogram prometheus.Histogram
 )
 
 func init() {
-	prometheus.MustRegister(requestsTotal)
-	prometheus.MustRegister(requestDuration)
+	reg.MustRegister(requestsTotal)
+	reg.MustRegister(requestDuration)
+	reg.MustRegister(rpcDurations)
+	reg.MustRegister(collectors.NewGoCollector())
+	reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	reg.MustRegister(collectors.NewBuildInfoCollector())
 }
 
-func updateMetrics() {
+// simulateLoad feeds rpcDurations with samples from uniform, normal and
+// exponential distributions, waiting 100ms*(1+sin(2*pi*t/oscillationPeriod))
+// between rounds, so the emission rate oscillates over each period (lowest
+// where the sine peaks) and scraped data has visible seasonality.
+// It runs until ctx is cancelled.
+func simulateLoad(ctx context.Context) {
+	start := time.Now()
 	for {
-		requestsTotal.Inc()
-		requestDuration.Observe(float64(time.Now().UnixNano()) / 1e9)
-		time.Sleep(5 * time.Second)
+		v := math.Sin(2 * math.Pi * float64(time.Since(start)) / float64(*oscillationPeriod))
+		delay := time.Duration(100*(1+v)) * time.Millisecond
+
+		rpcDurations.WithLabelValues("uniform").Observe(mathrand.Float64() * *uniformDomain)
+
+		normal := mathrand.NormFloat64()**normalDomain + *normalMean
+		rpcDurations.WithLabelValues("normal").Observe(normal)
+		rpcDurationsHistogram.Observe(normal)
+
+		rpcDurations.WithLabelValues("exponential").Observe(mathrand.ExpFloat64())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// statusRecorder wraps an http.ResponseWriter so the status code written by
+// the handler can be observed after it returns.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// traceID returns the request's X-Request-ID header, generating a random
+// one if the caller didn't supply it.
+func traceID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// InstrumentHandler wraps handler so every request served for path is
+// counted in requests_total and timed in request_duration_seconds, both
+// labelled by method and path. The duration observation carries an
+// exemplar linking it back to the request's trace ID.
+func InstrumentHandler(path string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := traceID(r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		handler(rec, r)
+
+		requestDuration.WithLabelValues(r.Method, path).(prometheus.ExemplarObserver).ObserveWithExemplar(
+			time.Since(start).Seconds(), prometheus.Labels{"traceID": id},
+		)
+		requestsTotal.WithLabelValues(r.Method, path, strconv.Itoa(rec.status)).Inc()
 	}
 }
 
+func helloHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("hello\n"))
+}
+
+func slowHandler(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(500 * time.Millisecond)
+	w.Write([]byte("slow\n"))
+}
+
 func main() {
-	go updateMetrics()
+	flag.Parse()
+
+	rpcDurationsHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rpc_durations_histogram_seconds",
+		Help:    "RPC latency distributions.",
+		Buckets: prometheus.LinearBuckets(*normalMean-5**normalDomain, .5**normalDomain, 20),
+	})
+	reg.MustRegister(rpcDurationsHistogram)
 
-	http.Handle("/metrics", promhttp.Handler())
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
\ No newline at end of file
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go simulateLoad(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", InstrumentHandler("/hello", helloHandler))
+	mux.HandleFunc("/slow", InstrumentHandler("/slow", slowHandler))
+	mux.Handle(*metricsPath, promhttp.HandlerFor(reg, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+		Registry:          reg,
+	}))
+
+	if targets := parseTargets(*aggregatorTargets); len(targets) > 0 {
+		agg := newAggregator(targets)
+		go agg.run(ctx, *aggregatorInterval)
+		mux.Handle("/federate", agg)
+		logger.Printf("aggregating %d targets onto /federate", len(targets))
+	}
+
+	srv := &http.Server{Addr: *listenAddress, Handler: mux}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Printf("listen: %v", err)
+		}
+	}()
+	logger.Printf("listening on %s", *listenAddress)
+
+	<-ctx.Done()
+	stop()
+	logger.Printf("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Printf("graceful shutdown failed: %v", err)
+	}
+}